@@ -0,0 +1,5 @@
+package common
+
+// ProtocolS3 identifies connections served by the s3d gateway, alongside
+// the existing ProtocolSFTP/ProtocolFTP/ProtocolWebDAV
+const ProtocolS3 = "S3"
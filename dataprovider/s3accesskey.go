@@ -0,0 +1,38 @@
+package dataprovider
+
+// S3AccessKey is a per-user AWS SigV4 credential pair, used by the s3d
+// gateway to authenticate requests against the same dataprovider.User
+// accounts served over WebDAV/SFTP/FTP
+type S3AccessKey struct {
+	AccessKeyID string
+	SecretKey   string
+	Username    string
+	Enabled     bool
+}
+
+// CheckS3AccessKey returns the S3AccessKey identified by accessKeyID,
+// together with the dataprovider.User it authenticates as
+func CheckS3AccessKey(accessKeyID string) (S3AccessKey, User, error) {
+	key, err := provider.getS3AccessKey(accessKeyID)
+	if err != nil {
+		return key, User{}, err
+	}
+	if !key.Enabled {
+		return key, User{}, ErrInvalidCredentials
+	}
+	user, err := UserExists(key.Username)
+	if err != nil {
+		return key, User{}, err
+	}
+	return key, user, nil
+}
+
+// AddS3AccessKey creates a new access key for a user
+func AddS3AccessKey(key *S3AccessKey) error {
+	return provider.addS3AccessKey(key)
+}
+
+// DeleteS3AccessKey revokes an access key
+func DeleteS3AccessKey(accessKeyID string) error {
+	return provider.deleteS3AccessKey(accessKeyID)
+}
@@ -0,0 +1,97 @@
+package dataprovider
+
+import (
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Share is a public, unauthenticated read-only link into a user's virtual
+// filesystem, served over WebDAV at /s/<Token>/...
+type Share struct {
+	ShareID      int64
+	Token        string
+	Owner        string
+	Path         string
+	Description  string
+	PasswordHash string
+	ExpiresAt    time.Time
+	AllowedIPs   []string
+	Downloads    int64
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	LastDownload time.Time
+}
+
+// IsExpired returns true if the share has passed its expiration, a zero
+// ExpiresAt means the share never expires
+func (s *Share) IsExpired() bool {
+	return !s.ExpiresAt.IsZero() && s.ExpiresAt.Before(time.Now())
+}
+
+// HasPassword returns true if a password is required to access the share
+func (s *Share) HasPassword() bool {
+	return s.PasswordHash != ""
+}
+
+// CheckPassword verifies password against the share's stored hash
+func (s *Share) CheckPassword(password string) bool {
+	if s.PasswordHash == "" {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword([]byte(s.PasswordHash), []byte(password)) == nil
+}
+
+// IsIPAllowed returns true if ip is allowed to use the share, an empty
+// AllowedIPs list means every address is allowed
+func (s *Share) IsIPAllowed(ip string) bool {
+	if len(s.AllowedIPs) == 0 {
+		return true
+	}
+	for _, allowed := range s.AllowedIPs {
+		if allowed == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// HashSharePassword hashes password for storage on a Share
+func HashSharePassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// AddShare creates a new share
+func AddShare(share *Share) error {
+	return provider.addShare(share)
+}
+
+// UpdateShare updates an existing share
+func UpdateShare(share *Share) error {
+	return provider.updateShare(share)
+}
+
+// DeleteShare removes a share
+func DeleteShare(token string) error {
+	return provider.deleteShare(token)
+}
+
+// ShareExists returns the share identified by token
+func ShareExists(token string) (Share, error) {
+	return provider.shareExists(token)
+}
+
+// GetShares returns every share owned by username, used by the admin API
+func GetShares(username string) ([]Share, error) {
+	return provider.getShares(username)
+}
+
+// IncrementShareDownloads increments the download counter for token and
+// records the last download time
+func IncrementShareDownloads(token string) error {
+	return provider.incrementShareDownloads(token)
+}
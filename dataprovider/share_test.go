@@ -0,0 +1,58 @@
+package dataprovider
+
+import (
+	"testing"
+	"time"
+)
+
+func TestShareIsExpired(t *testing.T) {
+	share := Share{}
+	if share.IsExpired() {
+		t.Error("a zero ExpiresAt must never report the share as expired")
+	}
+
+	share.ExpiresAt = time.Now().Add(time.Hour)
+	if share.IsExpired() {
+		t.Error("a future ExpiresAt must not report the share as expired")
+	}
+
+	share.ExpiresAt = time.Now().Add(-time.Hour)
+	if !share.IsExpired() {
+		t.Error("a past ExpiresAt must report the share as expired")
+	}
+}
+
+func TestShareIsIPAllowed(t *testing.T) {
+	share := Share{}
+	if !share.IsIPAllowed("1.2.3.4") {
+		t.Error("an empty AllowedIPs list must allow every address")
+	}
+
+	share.AllowedIPs = []string{"10.0.0.1", "10.0.0.2"}
+	if !share.IsIPAllowed("10.0.0.1") {
+		t.Error("expected an allow-listed IP to be allowed")
+	}
+	if share.IsIPAllowed("10.0.0.99") {
+		t.Error("expected a non-allow-listed IP to be denied")
+	}
+}
+
+func TestShareCheckPassword(t *testing.T) {
+	share := Share{}
+	if !share.CheckPassword("anything") {
+		t.Error("a share with no password must accept any password")
+	}
+
+	hash, err := HashSharePassword("s3cr3t")
+	if err != nil {
+		t.Fatalf("unexpected error hashing password: %v", err)
+	}
+	share.PasswordHash = hash
+
+	if !share.CheckPassword("s3cr3t") {
+		t.Error("expected the correct password to be accepted")
+	}
+	if share.CheckPassword("wrong") {
+		t.Error("expected an incorrect password to be rejected")
+	}
+}
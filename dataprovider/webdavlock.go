@@ -0,0 +1,44 @@
+package dataprovider
+
+import "time"
+
+// WebDAVLock is the persisted counterpart of an in-memory webdav.LockDetails.
+// Storing it in the dataprovider lets a LOCK token outlive a server restart
+// and be honored by every node in a multi-node deployment.
+type WebDAVLock struct {
+	Token      string
+	Username   string
+	Path       string
+	Owner      string
+	Depth      int
+	ZeroDepth  bool
+	Expiration time.Time
+}
+
+// IsExpired returns true if the lock TTL has elapsed
+func (l *WebDAVLock) IsExpired() bool {
+	return l.Expiration.Before(time.Now())
+}
+
+// AddWebDAVLock persists a newly created lock
+func AddWebDAVLock(lock WebDAVLock) error {
+	return provider.addWebDAVLock(lock)
+}
+
+// RemoveWebDAVLock removes a lock identified by its token, called on UNLOCK
+// or once the TTL sweep finds it expired
+func RemoveWebDAVLock(token string) error {
+	return provider.removeWebDAVLock(token)
+}
+
+// UpdateWebDAVLock refreshes the expiration of an existing lock
+func UpdateWebDAVLock(token string, expiration time.Time) error {
+	return provider.updateWebDAVLock(token, expiration)
+}
+
+// GetWebDAVLocks returns every lock currently persisted, used both to
+// rebuild in-memory state on startup and to expose lock state over the
+// admin API
+func GetWebDAVLocks() ([]WebDAVLock, error) {
+	return provider.getWebDAVLocks()
+}
@@ -0,0 +1,419 @@
+package dataprovider
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/drakkan/sftpgo/logger"
+)
+
+// LoginMethodToken identifies a successful authentication performed presenting
+// a bearer token instead of a password. It can be listed in
+// user.Filters.DeniedLoginMethods to forbid token based logins for a user.
+const LoginMethodToken = "token"
+
+var (
+	// ErrInvalidToken is returned when a bearer token cannot be mapped to a user
+	ErrInvalidToken = errors.New("invalid or expired token")
+
+	tokenVerifiersMu sync.RWMutex
+	tokenVerifiers   []TokenVerifier
+
+	cachedTokenUsers sync.Map
+)
+
+// TokenClaims exposes the scopes granted to a token, used to further
+// restrict what the authenticated connection can do.
+type TokenClaims struct {
+	Username  string
+	ReadOnly  bool
+	PathScope string
+	ExpiresAt time.Time
+}
+
+// TokenVerifier is implemented by the pluggable token backends: static
+// per-user tokens, HMAC-signed tokens and OIDC/JWT tokens validated against
+// a configured JWKS URL.
+type TokenVerifier interface {
+	// Name returns a short identifier used in log messages
+	Name() string
+	// Verify returns the claims encoded in token or an error if the token
+	// is unknown, malformed or expired
+	Verify(token string) (*TokenClaims, error)
+}
+
+// AddTokenVerifier registers a TokenVerifier, verifiers are tried in the
+// order they were added and the first one that recognizes the token wins
+func AddTokenVerifier(v TokenVerifier) {
+	tokenVerifiersMu.Lock()
+	defer tokenVerifiersMu.Unlock()
+
+	tokenVerifiers = append(tokenVerifiers, v)
+}
+
+// CachedTokenUser is the token based counterpart of CachedUser: it caches a
+// successful token authentication, keyed on the token hash rather than on
+// a password, so distinct tokens issued to the same user are tracked
+// independently and a revoked token does not evict the others
+type CachedTokenUser struct {
+	User       User
+	Claims     TokenClaims
+	LockSystem webdav.LockSystem
+}
+
+// IsExpired returns true if the cached token has passed its expiration
+func (c *CachedTokenUser) IsExpired() bool {
+	if c.Claims.ExpiresAt.IsZero() {
+		return false
+	}
+	return c.Claims.ExpiresAt.Before(time.Now())
+}
+
+// HashToken returns a stable, non-reversible identifier for token, it is
+// used as the cache key so that raw tokens never have to be retained in
+// memory
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// GetCachedWebDAVUserByToken returns the user cached for the given token hash
+func GetCachedWebDAVUserByToken(tokenHash string) (*CachedTokenUser, bool) {
+	result, ok := cachedTokenUsers.Load(tokenHash)
+	if !ok {
+		return nil, false
+	}
+	return result.(*CachedTokenUser), true
+}
+
+// RemoveCachedWebDAVUserByToken removes a cached token mapping, used once a
+// token is found to be expired or revoked
+func RemoveCachedWebDAVUserByToken(tokenHash string) {
+	cachedTokenUsers.Delete(tokenHash)
+}
+
+// CacheWebDAVUserByToken stores cachedUser under tokenHash. maxSize mirrors
+// the limit honored by CacheWebDAVUser, entries beyond it are not cached
+func CacheWebDAVUserByToken(tokenHash string, cachedUser *CachedTokenUser, maxSize int) {
+	if maxSize > 0 {
+		var count int
+		cachedTokenUsers.Range(func(_, _ interface{}) bool {
+			count++
+			return count <= maxSize
+		})
+		if count >= maxSize {
+			return
+		}
+	}
+	cachedTokenUsers.Store(tokenHash, cachedUser)
+}
+
+// CheckUserToken verifies token against the configured TokenVerifier chain
+// and, on success, returns the dataprovider.User the token maps to
+func CheckUserToken(token, ip, protocol string) (User, TokenClaims, error) {
+	var user User
+	var claims TokenClaims
+
+	if token == "" {
+		return user, claims, ErrInvalidToken
+	}
+
+	tokenVerifiersMu.RLock()
+	verifiers := tokenVerifiers
+	tokenVerifiersMu.RUnlock()
+
+	var lastErr error
+	for _, v := range verifiers {
+		c, err := v.Verify(token)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		u, err := UserExists(c.Username)
+		if err != nil {
+			logger.Debug(logSender, "", "token verified by %#v but user %#v does not exist: %v", v.Name(), c.Username, err)
+			return user, claims, ErrInvalidToken
+		}
+		if !u.IsLoginMethodAllowed(LoginMethodToken, nil) {
+			logger.Debug(logSender, "", "token login denied for user %#v by configured login methods", c.Username)
+			return user, claims, ErrInvalidToken
+		}
+		return u, *c, nil
+	}
+	if lastErr != nil {
+		logger.Debug(logSender, "", "unable to verify webdav token from ip %#v, protocol %v: %v", ip, protocol, lastErr)
+	}
+	return user, claims, ErrInvalidToken
+}
+
+// staticTokenVerifier matches tokens stored verbatim on the user
+type staticTokenVerifier struct {
+	// tokens maps a static token to the username it authenticates as
+	tokens map[string]string
+}
+
+// NewStaticTokenVerifier returns a TokenVerifier backed by a static
+// token -> username mapping, as configured per user in the dataprovider
+func NewStaticTokenVerifier(tokens map[string]string) TokenVerifier {
+	return &staticTokenVerifier{tokens: tokens}
+}
+
+func (v *staticTokenVerifier) Name() string { return "static" }
+
+func (v *staticTokenVerifier) Verify(token string) (*TokenClaims, error) {
+	username, ok := v.tokens[token]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+	return &TokenClaims{Username: username}, nil
+}
+
+// hmacTokenVerifier validates short-lived tokens of the form
+// <username>.<expiresUnix>.<scope>.<base64url(hmac)>
+type hmacTokenVerifier struct {
+	secret []byte
+}
+
+// NewHMACTokenVerifier returns a TokenVerifier for HMAC-signed tokens with
+// expiry and an optional scope (e.g. a read-only flag or a path prefix)
+func NewHMACTokenVerifier(secret []byte) TokenVerifier {
+	return &hmacTokenVerifier{secret: secret}
+}
+
+func (v *hmacTokenVerifier) Name() string { return "hmac" }
+
+func (v *hmacTokenVerifier) Verify(token string) (*TokenClaims, error) {
+	parts := strings.SplitN(token, ".", 4)
+	if len(parts) != 4 {
+		return nil, ErrInvalidToken
+	}
+	username, expires, scope, sig := parts[0], parts[1], parts[2], parts[3]
+
+	mac := hmac.New(sha256.New, v.secret)
+	mac.Write([]byte(username + "." + expires + "." + scope)) //nolint:errcheck
+	expectedSig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(sig), []byte(expectedSig)) {
+		return nil, ErrInvalidToken
+	}
+
+	expiresUnix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid expiration", ErrInvalidToken)
+	}
+	expiresAt := time.Unix(expiresUnix, 0)
+	if expiresAt.Before(time.Now()) {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+
+	claims := &TokenClaims{
+		Username:  username,
+		ExpiresAt: expiresAt,
+	}
+	switch {
+	case scope == "ro":
+		claims.ReadOnly = true
+	case strings.HasPrefix(scope, "path:"):
+		claims.PathScope = strings.TrimPrefix(scope, "path:")
+	}
+	return claims, nil
+}
+
+// jwksCacheTTL bounds how long a fetched JWKS document is trusted before
+// jwksTokenVerifier refreshes it from jwksURL again
+const jwksCacheTTL = 10 * time.Minute
+
+// jwksKey is the subset of a JSON Web Key consumed here: an RSA public key
+// identified by its "kid", as published by an OIDC provider's JWKS endpoint
+type jwksKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwksDocument struct {
+	Keys []jwksKey `json:"keys"`
+}
+
+// jwksTokenVerifier validates OIDC/JWT access tokens against a configured
+// JWKS URL: it verifies the RS256 signature over the header and payload
+// against the matching key fetched from jwksURL before trusting any claim.
+type jwksTokenVerifier struct {
+	jwksURL       string
+	usernameClaim string
+	httpClient    *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// NewJWKSTokenVerifier returns a TokenVerifier that validates JWT access
+// tokens against the keys published at jwksURL. usernameClaim selects which
+// JWT claim is mapped to the SFTPGo username, defaults to "sub" when empty.
+func NewJWKSTokenVerifier(jwksURL, usernameClaim string) TokenVerifier {
+	if usernameClaim == "" {
+		usernameClaim = "sub"
+	}
+	return &jwksTokenVerifier{
+		jwksURL:       jwksURL,
+		usernameClaim: usernameClaim,
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+		keys:          make(map[string]*rsa.PublicKey),
+	}
+}
+
+func (v *jwksTokenVerifier) Name() string { return "jwks" }
+
+func (v *jwksTokenVerifier) Verify(token string) (*TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+	var hdr struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(header, &hdr); err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+	if hdr.Alg != "RS256" {
+		return nil, fmt.Errorf("%w: unsupported signing algorithm %#v", ErrInvalidToken, hdr.Alg)
+	}
+	key, err := v.getKey(hdr.Kid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+		return nil, fmt.Errorf("%w: signature verification failed", ErrInvalidToken)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed payload", ErrInvalidToken)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+	username, ok := claims[v.usernameClaim].(string)
+	if !ok || username == "" {
+		return nil, fmt.Errorf("%w: missing %#v claim", ErrInvalidToken, v.usernameClaim)
+	}
+	result := &TokenClaims{Username: username}
+	if exp, ok := claims["exp"].(float64); ok {
+		result.ExpiresAt = time.Unix(int64(exp), 0)
+		if result.ExpiresAt.Before(time.Now()) {
+			return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+		}
+	}
+	return result, nil
+}
+
+// getKey returns the RSA public key for kid, fetching/refreshing the JWKS
+// document from jwksURL when the cache is empty, stale or missing the key
+func (v *jwksTokenVerifier) getKey(kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	key, ok := v.keys[kid]
+	stale := time.Since(v.fetchedAt) > jwksCacheTTL
+	v.mu.Unlock()
+	if ok && !stale {
+		return key, nil
+	}
+
+	if err := v.refreshKeys(); err != nil {
+		if ok {
+			// serve the stale key rather than locking everyone out because
+			// the JWKS endpoint is briefly unreachable
+			return key, nil
+		}
+		return nil, err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	key, ok = v.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %#v", kid)
+	}
+	return key, nil
+}
+
+func (v *jwksTokenVerifier) refreshKeys() error {
+	resp, err := v.httpClient.Get(v.jwksURL)
+	if err != nil {
+		return fmt.Errorf("unable to fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status fetching jwks: %v", resp.Status)
+	}
+	var doc jwksDocument
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("unable to decode jwks: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" || k.N == "" || k.E == "" {
+			continue
+		}
+		pub, err := parseRSAPublicKey(k.N, k.E)
+		if err != nil {
+			logger.Warn(logSender, "", "unable to parse jwks key %#v: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	v.mu.Unlock()
+	return nil
+}
+
+// parseRSAPublicKey builds an rsa.PublicKey from the base64url-encoded
+// modulus and exponent of a JWK, as published by a JWKS endpoint
+func parseRSAPublicKey(nb64, eb64 string) (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(nb64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus: %w", err)
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(eb64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent: %w", err)
+	}
+	var e int
+	for _, b := range eBytes {
+		e = e<<8 | int(b)
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: e,
+	}, nil
+}
@@ -0,0 +1,256 @@
+package s3d
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+)
+
+var (
+	errMissingSignature = errors.New("missing AWS SigV4 signature")
+	errInvalidSignature = errors.New("invalid AWS SigV4 signature")
+	errRequestExpired   = errors.New("request signature has expired")
+	errBodyHashMismatch = errors.New("request body does not match X-Amz-Content-Sha256")
+)
+
+const (
+	amzDateFormat    = "20060102T150405Z"
+	amzDateOnlyFormt = "20060102"
+	signatureMaxSkew = 15 * time.Minute
+	// presignedDefaultExpiry mirrors the default AWS clients assume when a
+	// presigned URL omits X-Amz-Expires
+	presignedDefaultExpiry = 15 * time.Minute
+	// presignedMaxExpiry is the upper bound AWS itself enforces for SigV4
+	// presigned URLs
+	presignedMaxExpiry = 7 * 24 * time.Hour
+)
+
+// authenticate validates the AWS SigV4 signature carried by r, either in the
+// Authorization header or, for presigned URLs, in the query string, and
+// returns the dataprovider.User the access key belongs to
+func (s *s3Server) authenticate(r *http.Request) (dataprovider.User, error) {
+	if sig := r.URL.Query().Get("X-Amz-Signature"); sig != "" {
+		return s.authenticatePresigned(r)
+	}
+	return s.authenticateHeader(r)
+}
+
+func (s *s3Server) authenticateHeader(r *http.Request) (dataprovider.User, error) {
+	var user dataprovider.User
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 ") {
+		return user, errMissingSignature
+	}
+	fields := parseAuthHeader(strings.TrimPrefix(auth, "AWS4-HMAC-SHA256 "))
+	accessKeyID, scope, signedHeaders, signature := fields["Credential"], "", fields["SignedHeaders"], fields["Signature"]
+	if idx := strings.Index(accessKeyID, "/"); idx != -1 {
+		scope = accessKeyID[idx+1:]
+		accessKeyID = accessKeyID[:idx]
+	}
+	if accessKeyID == "" || signature == "" || signedHeaders == "" {
+		return user, errMissingSignature
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	reqTime, err := time.Parse(amzDateFormat, amzDate)
+	if err != nil {
+		return user, errMissingSignature
+	}
+	if time.Since(reqTime).Abs() > signatureMaxSkew {
+		return user, errRequestExpired
+	}
+
+	key, u, err := dataprovider.CheckS3AccessKey(accessKeyID)
+	if err != nil {
+		return user, errInvalidSignature
+	}
+
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	canonicalRequest := buildCanonicalRequest(r, signedHeaders, payloadHash)
+	stringToSign := buildStringToSign(amzDate, scope, canonicalRequest)
+	expected := s.signature(key.SecretKey, reqTime, stringToSign)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return user, errInvalidSignature
+	}
+	if err := verifyPayloadHash(r, payloadHash); err != nil {
+		return user, err
+	}
+	return u, nil
+}
+
+// verifyPayloadHash checks that r.Body actually hashes to payloadHash when
+// the client claims a real SHA-256 digest of it, buffering the body so
+// downstream handlers (putObject, uploadPart) can still read it afterwards.
+// UNSIGNED-PAYLOAD and the chunked STREAMING-* schemes carry no body hash to
+// check against, so they pass through unverified, as they already tell the
+// client that AWS itself treats the body as unsigned.
+func verifyPayloadHash(r *http.Request, payloadHash string) error {
+	if payloadHash == "" || payloadHash == "UNSIGNED-PAYLOAD" || strings.HasPrefix(payloadHash, "STREAMING-") {
+		return nil
+	}
+	if len(payloadHash) != sha256.Size*2 {
+		return errBodyHashMismatch
+	}
+	if _, err := hex.DecodeString(payloadHash); err != nil {
+		return errBodyHashMismatch
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	sum := sha256.Sum256(body)
+	if !hmac.Equal([]byte(hex.EncodeToString(sum[:])), []byte(strings.ToLower(payloadHash))) {
+		return errBodyHashMismatch
+	}
+	return nil
+}
+
+func (s *s3Server) authenticatePresigned(r *http.Request) (dataprovider.User, error) {
+	var user dataprovider.User
+	q := r.URL.Query()
+	accessKeyID, scope := "", ""
+	if cred := q.Get("X-Amz-Credential"); cred != "" {
+		if idx := strings.Index(cred, "/"); idx != -1 {
+			accessKeyID, scope = cred[:idx], cred[idx+1:]
+		}
+	}
+	signature := q.Get("X-Amz-Signature")
+	signedHeaders := q.Get("X-Amz-SignedHeaders")
+	amzDate := q.Get("X-Amz-Date")
+	if accessKeyID == "" || signature == "" || amzDate == "" {
+		return user, errMissingSignature
+	}
+	reqTime, err := time.Parse(amzDateFormat, amzDate)
+	if err != nil {
+		return user, errMissingSignature
+	}
+	expires := presignedDefaultExpiry
+	if raw := q.Get("X-Amz-Expires"); raw != "" {
+		seconds, convErr := strconv.Atoi(raw)
+		if convErr != nil || seconds <= 0 {
+			return user, errMissingSignature
+		}
+		expires = time.Duration(seconds) * time.Second
+		if expires > presignedMaxExpiry {
+			return user, errMissingSignature
+		}
+	}
+	now := time.Now()
+	if now.Before(reqTime.Add(-signatureMaxSkew)) || now.After(reqTime.Add(expires)) {
+		return user, errRequestExpired
+	}
+
+	key, u, err := dataprovider.CheckS3AccessKey(accessKeyID)
+	if err != nil {
+		return user, errInvalidSignature
+	}
+
+	unsigned := *r.URL
+	values := unsigned.Query()
+	values.Del("X-Amz-Signature")
+	unsigned.RawQuery = values.Encode()
+	signed := *r
+	signed.URL = &unsigned
+
+	canonicalRequest := buildCanonicalRequest(&signed, signedHeaders, "UNSIGNED-PAYLOAD")
+	stringToSign := buildStringToSign(amzDate, scope, canonicalRequest)
+	expected := s.signature(key.SecretKey, reqTime, stringToSign)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return user, errInvalidSignature
+	}
+	return u, nil
+}
+
+func (s *s3Server) signature(secretKey string, t time.Time, stringToSign string) string {
+	dateKey := hmacSHA256([]byte("AWS4"+secretKey), t.Format(amzDateOnlyFormt))
+	regionKey := hmacSHA256(dateKey, Config.Region)
+	serviceKey := hmacSHA256(regionKey, "s3")
+	signingKey := hmacSHA256(serviceKey, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data)) //nolint:errcheck
+	return mac.Sum(nil)
+}
+
+func buildCanonicalRequest(r *http.Request, signedHeaders, payloadHash string) string {
+	headerNames := strings.Split(signedHeaders, ";")
+	sort.Strings(headerNames)
+	var canonicalHeaders strings.Builder
+	for _, name := range headerNames {
+		value := r.Header.Get(name)
+		if strings.EqualFold(name, "host") && value == "" {
+			value = r.Host
+		}
+		canonicalHeaders.WriteString(strings.ToLower(name))
+		canonicalHeaders.WriteString(":")
+		canonicalHeaders.WriteString(strings.TrimSpace(value))
+		canonicalHeaders.WriteString("\n")
+	}
+
+	return strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		canonicalQueryString(r.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+}
+
+func canonicalQueryString(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteString("&")
+		}
+		b.WriteString(url.QueryEscape(k))
+		b.WriteString("=")
+		b.WriteString(url.QueryEscape(values.Get(k)))
+	}
+	return b.String()
+}
+
+func buildStringToSign(amzDate, scope, canonicalRequest string) string {
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	return strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+}
+
+// parseAuthHeader splits the comma separated Key=Value list carried after
+// the AWS4-HMAC-SHA256 prefix of the Authorization header
+func parseAuthHeader(s string) map[string]string {
+	result := make(map[string]string)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if idx := strings.Index(part, "="); idx != -1 {
+			result[part[:idx]] = part[idx+1:]
+		}
+	}
+	return result
+}
@@ -0,0 +1,71 @@
+package s3d
+
+import (
+	"io"
+	"os"
+
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+// Connection wraps a common.BaseConnection the same way webdavd, sftpd and
+// ftpd do, so a bucket served over S3 enforces the very same quota, user
+// permissions and actions hooks as every other protocol. Every object
+// operation below goes through the embedded BaseConnection rather than the
+// vfs.Fs directly, the same pattern webdavd.Connection uses for Stat,
+// OpenFile, RemoveAll and Rename.
+type Connection struct {
+	*common.BaseConnection
+}
+
+// NewConnection creates a Connection for an authenticated S3 request
+func NewConnection(connectionID string, user dataprovider.User, fs vfs.Fs) *Connection {
+	return &Connection{
+		BaseConnection: common.NewBaseConnection(connectionID, common.ProtocolS3, user, fs),
+	}
+}
+
+// GetObject opens name for reading through the BaseConnection, so a missing
+// download permission or quota violation is rejected the same way it would
+// be over WebDAV, SFTP or FTP
+func (c *Connection) GetObject(name string) (io.ReadCloser, os.FileInfo, error) {
+	info, err := c.Stat(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := c.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, info, nil
+}
+
+// PutObject writes the body of an upload to name through the BaseConnection
+func (c *Connection) PutObject(name string, body io.Reader) (int64, error) {
+	file, err := c.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
+
+// DeleteObject removes name through the BaseConnection
+func (c *Connection) DeleteObject(name string) error {
+	return c.RemoveAll(name)
+}
+
+// ListObjects lists the immediate contents of prefix through the
+// BaseConnection
+func (c *Connection) ListObjects(prefix string) ([]os.FileInfo, error) {
+	file, err := c.OpenFile(prefix, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close() //nolint:errcheck
+	return file.Readdir(-1)
+}
@@ -0,0 +1,297 @@
+package s3d
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/logger"
+)
+
+const (
+	// multipartUploadTTL bounds how long an initiated-but-never-completed
+	// or -aborted upload, and its buffered temp parts, are kept around
+	multipartUploadTTL     = 24 * time.Hour
+	multipartSweepInterval = 10 * time.Minute
+)
+
+// multipartPart is one uploaded, not-yet-assembled part of a multipart
+// upload, backed by a temporary file on disk
+type multipartPart struct {
+	path string
+	etag string
+	size int64
+}
+
+// multipartUpload tracks the parts received so far for one
+// CreateMultipartUpload/CompleteMultipartUpload session. It is scoped to
+// the username and bucket it was created under so one user can't
+// upload/complete/abort a part of another user's in-flight upload just by
+// guessing or observing its uploadID.
+type multipartUpload struct {
+	mu        sync.Mutex
+	username  string
+	bucket    string
+	key       string
+	createdAt time.Time
+	parts     map[int]*multipartPart
+}
+
+var (
+	multipartMu          sync.Mutex
+	multipartUploads     = make(map[string]*multipartUpload)
+	multipartSweeperOnce sync.Once
+)
+
+// startMultipartSweeper lazily starts the background goroutine that
+// reclaims abandoned multipart uploads and their on-disk parts, mirroring
+// how the webdav lock sweeper is lazily started on first use
+func startMultipartSweeper() {
+	multipartSweeperOnce.Do(func() {
+		go func() {
+			ticker := time.NewTicker(multipartSweepInterval)
+			defer ticker.Stop()
+			for range ticker.C {
+				sweepMultipartUploads()
+			}
+		}()
+	})
+}
+
+func sweepMultipartUploads() {
+	deadline := time.Now().Add(-multipartUploadTTL)
+	multipartMu.Lock()
+	var stale []string
+	for uploadID, upload := range multipartUploads {
+		if upload.createdAt.Before(deadline) {
+			stale = append(stale, uploadID)
+		}
+	}
+	multipartMu.Unlock()
+
+	for _, uploadID := range stale {
+		removeMultipartUpload(uploadID)
+	}
+	if len(stale) > 0 {
+		logger.Debug(logSender, "", "swept %v abandoned multipart uploads", len(stale))
+	}
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type completeMultipartUploadRequest struct {
+	XMLName xml.Name `xml:"CompleteMultipartUpload"`
+	Parts   []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+	Key     string   `xml:"Key"`
+	ETag    string   `xml:"ETag"`
+}
+
+// createMultipartUpload starts a new multipart upload session for key,
+// scoped to connection's user and bucket, answering the
+// "POST /bucket/key?uploads" request
+func (s *s3Server) createMultipartUpload(w http.ResponseWriter, r *http.Request, connection *Connection, bucket, key string) {
+	if !isValidBucketKey(key) {
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "invalid object key")
+		return
+	}
+	startMultipartSweeper()
+
+	uploadID := xid.New().String()
+	multipartMu.Lock()
+	multipartUploads[uploadID] = &multipartUpload{
+		username:  connection.GetUsername(),
+		bucket:    bucket,
+		key:       key,
+		createdAt: time.Now(),
+		parts:     make(map[int]*multipartPart),
+	}
+	multipartMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	writeXML(w, initiateMultipartUploadResult{Key: key, UploadID: uploadID})
+}
+
+// uploadPart stores the body of "PUT /bucket/key?partNumber=N&uploadId=ID"
+// as a temporary file, to be assembled on CompleteMultipartUpload
+func (s *s3Server) uploadPart(w http.ResponseWriter, r *http.Request, connection *Connection, bucket, uploadID, partNumberRaw string) {
+	upload := getOwnedMultipartUpload(uploadID, connection.GetUsername(), bucket)
+	if upload == nil {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchUpload", "no such multipart upload")
+		return
+	}
+	partNumber, err := strconv.Atoi(partNumberRaw)
+	if err != nil || partNumber < 1 {
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "invalid part number")
+		return
+	}
+
+	tmp, err := os.CreateTemp("", "s3d-part-*")
+	if err != nil {
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	hash := md5.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r.Body)
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name()) //nolint:errcheck
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	etag := hex.EncodeToString(hash.Sum(nil))
+
+	upload.mu.Lock()
+	if old, ok := upload.parts[partNumber]; ok {
+		if err := os.Remove(old.path); err != nil && !os.IsNotExist(err) {
+			logger.Warn(logSender, "", "unable to remove replaced part %#v: %v", old.path, err)
+		}
+	}
+	upload.parts[partNumber] = &multipartPart{path: tmp.Name(), etag: etag, size: size}
+	upload.mu.Unlock()
+
+	w.Header().Set("ETag", `"`+etag+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+// completeMultipartUpload assembles the parts named in the request body, in
+// the order given, into a single object written through connection, so the
+// same permission/quota/actions-hook path a single PutObject goes through
+// applies to the assembled object too
+func (s *s3Server) completeMultipartUpload(w http.ResponseWriter, r *http.Request, connection *Connection, bucket, uploadID string) {
+	upload := getOwnedMultipartUpload(uploadID, connection.GetUsername(), bucket)
+	if upload == nil {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchUpload", "no such multipart upload")
+		return
+	}
+
+	var req completeMultipartUploadRequest
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeS3Error(w, r, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+
+	upload.mu.Lock()
+	readers := make([]io.Reader, 0, len(req.Parts))
+	files := make([]*os.File, 0, len(req.Parts))
+	var etags []string
+	for _, p := range req.Parts {
+		part, ok := upload.parts[p.PartNumber]
+		if !ok {
+			upload.mu.Unlock()
+			closeAll(files)
+			writeS3Error(w, r, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d was not uploaded", p.PartNumber))
+			return
+		}
+		f, err := os.Open(part.path)
+		if err != nil {
+			upload.mu.Unlock()
+			closeAll(files)
+			writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+			return
+		}
+		files = append(files, f)
+		readers = append(readers, f)
+		etags = append(etags, part.etag)
+	}
+	key := upload.key
+	upload.mu.Unlock()
+
+	_, err := connection.PutObject(toVirtualPath(key), io.MultiReader(readers...))
+	closeAll(files)
+	removeMultipartUpload(uploadID)
+	if err != nil {
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	writeXML(w, completeMultipartUploadResult{Key: key, ETag: `"` + multipartETag(etags) + `"`})
+}
+
+// abortMultipartUpload discards a multipart upload session and its
+// buffered parts, answering "DELETE /bucket/key?uploadId=ID"
+func (s *s3Server) abortMultipartUpload(w http.ResponseWriter, r *http.Request, connection *Connection, bucket, uploadID string) {
+	if getOwnedMultipartUpload(uploadID, connection.GetUsername(), bucket) == nil {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchUpload", "no such multipart upload")
+		return
+	}
+	removeMultipartUpload(uploadID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getOwnedMultipartUpload returns the upload for uploadID, or nil if it
+// doesn't exist or was created by a different user/bucket, so one user
+// can't touch another's in-flight upload by guessing its uploadID
+func getOwnedMultipartUpload(uploadID, username, bucket string) *multipartUpload {
+	multipartMu.Lock()
+	defer multipartMu.Unlock()
+	upload, ok := multipartUploads[uploadID]
+	if !ok || upload.username != username || upload.bucket != bucket {
+		return nil
+	}
+	return upload
+}
+
+func removeMultipartUpload(uploadID string) {
+	multipartMu.Lock()
+	upload, ok := multipartUploads[uploadID]
+	delete(multipartUploads, uploadID)
+	multipartMu.Unlock()
+	if !ok {
+		return
+	}
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+	for _, part := range upload.parts {
+		if err := os.Remove(part.path); err != nil && !os.IsNotExist(err) {
+			logger.Warn(logSender, "", "unable to remove multipart part %#v: %v", part.path, err)
+		}
+	}
+}
+
+func closeAll(files []*os.File) {
+	for _, f := range files {
+		f.Close() //nolint:errcheck
+	}
+}
+
+// multipartETag mirrors the "<hash>-<count>" ETag format S3 itself returns
+// for an assembled multipart object, it is not a content MD5 of the whole
+// object, just like a single-part ETag is already only a best effort
+func multipartETag(partETags []string) string {
+	hash := md5.New()
+	for _, etag := range partETags {
+		raw, err := hex.DecodeString(etag)
+		if err != nil {
+			hash.Write([]byte(etag)) //nolint:errcheck
+			continue
+		}
+		hash.Write(raw) //nolint:errcheck
+	}
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(hash.Sum(nil)), len(partETags))
+}
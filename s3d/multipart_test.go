@@ -0,0 +1,70 @@
+package s3d
+
+import (
+	"testing"
+	"time"
+)
+
+func resetMultipartUploads() {
+	multipartMu.Lock()
+	multipartUploads = make(map[string]*multipartUpload)
+	multipartMu.Unlock()
+}
+
+func TestGetOwnedMultipartUploadScoping(t *testing.T) {
+	resetMultipartUploads()
+	defer resetMultipartUploads()
+
+	multipartMu.Lock()
+	multipartUploads["upload1"] = &multipartUpload{
+		username:  "alice",
+		bucket:    "alice-bucket",
+		createdAt: time.Now(),
+		parts:     make(map[int]*multipartPart),
+	}
+	multipartMu.Unlock()
+
+	if getOwnedMultipartUpload("upload1", "alice", "alice-bucket") == nil {
+		t.Error("expected the owning user/bucket to retrieve the upload")
+	}
+	if getOwnedMultipartUpload("upload1", "bob", "alice-bucket") != nil {
+		t.Error("expected a different user to not retrieve another user's upload")
+	}
+	if getOwnedMultipartUpload("upload1", "alice", "other-bucket") != nil {
+		t.Error("expected a mismatched bucket to not retrieve the upload")
+	}
+	if getOwnedMultipartUpload("no-such-upload", "alice", "alice-bucket") != nil {
+		t.Error("expected an unknown uploadID to return nil")
+	}
+}
+
+func TestSweepMultipartUploadsReclaimsStale(t *testing.T) {
+	resetMultipartUploads()
+	defer resetMultipartUploads()
+
+	multipartMu.Lock()
+	multipartUploads["stale"] = &multipartUpload{
+		username:  "alice",
+		bucket:    "alice-bucket",
+		createdAt: time.Now().Add(-2 * multipartUploadTTL),
+		parts:     make(map[int]*multipartPart),
+	}
+	multipartUploads["fresh"] = &multipartUpload{
+		username:  "alice",
+		bucket:    "alice-bucket",
+		createdAt: time.Now(),
+		parts:     make(map[int]*multipartPart),
+	}
+	multipartMu.Unlock()
+
+	sweepMultipartUploads()
+
+	multipartMu.Lock()
+	defer multipartMu.Unlock()
+	if _, ok := multipartUploads["stale"]; ok {
+		t.Error("expected the upload past its TTL to be reclaimed")
+	}
+	if _, ok := multipartUploads["fresh"]; !ok {
+		t.Error("expected the upload within its TTL to remain")
+	}
+}
@@ -0,0 +1,229 @@
+package s3d
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/drakkan/sftpgo/logger"
+)
+
+// splitPath returns the "/" separated components of s, used to reject keys
+// that try to escape the bucket root with a ".." component
+func splitPath(s string) []string {
+	return strings.Split(s, "/")
+}
+
+// toVirtualPath normalizes an S3 object key, which arrives without a
+// leading "/", into the "/"-rooted virtual path BaseConnection expects,
+// the same form webdavd derives from the request URL
+func toVirtualPath(key string) string {
+	return "/" + strings.TrimPrefix(key, "/")
+}
+
+func (s *s3Server) getObject(w http.ResponseWriter, r *http.Request, connection *Connection, key string, headOnly bool) {
+	if !isValidBucketKey(key) {
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "invalid object key")
+		return
+	}
+	body, info, err := connection.GetObject(toVirtualPath(key))
+	if err != nil {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchKey", err.Error())
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Length", formatInt64(info.Size()))
+	w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+	w.Header().Set("ETag", `"`+quoteETag(info)+`"`)
+	if headOnly {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	io.Copy(w, body) //nolint:errcheck
+}
+
+func (s *s3Server) putObject(w http.ResponseWriter, r *http.Request, connection *Connection, key string) {
+	if !isValidBucketKey(key) {
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "invalid object key")
+		return
+	}
+	if _, err := connection.PutObject(toVirtualPath(key), r.Body); err != nil {
+		logger.Warn(logSender, connection.GetID(), "unable to write object %#v: %v", key, err)
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *s3Server) deleteObject(w http.ResponseWriter, r *http.Request, connection *Connection, key string) {
+	if !isValidBucketKey(key) {
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidArgument", "invalid object key")
+		return
+	}
+	if err := connection.DeleteObject(toVirtualPath(key)); err != nil {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchKey", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listObjectsV2Result mirrors the subset of the S3 ListObjectsV2 response
+// consumed by every mainstream S3 client
+type listObjectsV2Result struct {
+	XMLName               xml.Name         `xml:"ListBucketResult"`
+	Name                  string           `xml:"Name"`
+	Prefix                string           `xml:"Prefix"`
+	KeyCount              int              `xml:"KeyCount"`
+	MaxKeys               int              `xml:"MaxKeys"`
+	IsTruncated           bool             `xml:"IsTruncated"`
+	ContinuationToken     string           `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string           `xml:"NextContinuationToken,omitempty"`
+	Contents              []listObjectItem `xml:"Contents"`
+}
+
+type listObjectItem struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	Size         int64  `xml:"Size"`
+	ETag         string `xml:"ETag"`
+}
+
+const listObjectsV2MaxKeys = 1000
+
+func (s *s3Server) listObjectsV2(w http.ResponseWriter, r *http.Request, connection *Connection, bucket string) {
+	q := r.URL.Query()
+	prefix := q.Get("prefix")
+
+	maxKeys := listObjectsV2MaxKeys
+	if raw := q.Get("max-keys"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 && n < maxKeys {
+			maxKeys = n
+		}
+	}
+	// the continuation token this gateway hands back is simply the last key
+	// of the previous page, not an opaque value, which is enough to resume
+	// a listing and keeps the implementation honest about what it supports
+	continuationToken := q.Get("continuation-token")
+	startAfter := q.Get("start-after")
+	if continuationToken != "" {
+		startAfter = continuationToken
+	}
+
+	items, err := s.walkObjects(connection, "/", prefix)
+	if err != nil {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchBucket", err.Error())
+		return
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+
+	result := listObjectsV2Result{
+		Name:              bucket,
+		Prefix:            prefix,
+		MaxKeys:           maxKeys,
+		ContinuationToken: continuationToken,
+	}
+	for _, item := range items {
+		if startAfter != "" && item.Key <= startAfter {
+			continue
+		}
+		if len(result.Contents) == maxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = result.Contents[len(result.Contents)-1].Key
+			break
+		}
+		result.Contents = append(result.Contents, item)
+	}
+	result.KeyCount = len(result.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	writeXML(w, result)
+}
+
+// walkObjects recursively lists every object under root whose key matches
+// prefix, descending into sub-directories through the same permission
+// enforced connection.ListObjects call the top level listing uses, so
+// nested objects are no longer silently skipped
+func (s *s3Server) walkObjects(connection *Connection, root, prefix string) ([]listObjectItem, error) {
+	entries, err := connection.ListObjects(root)
+	if err != nil {
+		return nil, err
+	}
+	var items []listObjectItem
+	for _, entry := range entries {
+		key := strings.TrimPrefix(path.Join(root, entry.Name()), "/")
+		if entry.IsDir() {
+			if !dirMayContainPrefix(key, prefix) {
+				continue
+			}
+			children, err := s.walkObjects(connection, "/"+key, prefix)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, children...)
+			continue
+		}
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		items = append(items, listObjectItem{
+			Key:          key,
+			LastModified: entry.ModTime().UTC().Format("2006-01-02T15:04:05.000Z"),
+			Size:         entry.Size(),
+			ETag:         `"` + quoteETag(entry) + `"`,
+		})
+	}
+	return items, nil
+}
+
+// dirMayContainPrefix reports whether a directory keyed dirKey could
+// contain an object key matching prefix, either because it is already
+// within prefix or because prefix may still resolve to something inside it
+func dirMayContainPrefix(dirKey, prefix string) bool {
+	return prefix == "" || strings.HasPrefix(dirKey, prefix) || strings.HasPrefix(prefix, dirKey)
+}
+
+type s3ErrorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource"`
+	RequestID string   `xml:"RequestId"`
+}
+
+func writeS3Error(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	writeXML(w, s3ErrorResponse{
+		Code:     code,
+		Message:  message,
+		Resource: r.URL.Path,
+	})
+}
+
+func writeXML(w io.Writer, v interface{}) {
+	w.Write([]byte(xml.Header)) //nolint:errcheck
+	enc := xml.NewEncoder(w)
+	enc.Encode(v) //nolint:errcheck
+}
+
+func formatInt64(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+// quoteETag derives a best-effort ETag from the object's size and
+// modification time, it is not a content MD5 since the underlying vfs
+// backends don't all expose one cheaply
+func quoteETag(info os.FileInfo) string {
+	sum := md5.Sum([]byte(fmt.Sprintf("%s-%d-%d", info.Name(), info.Size(), info.ModTime().UnixNano())))
+	return hex.EncodeToString(sum[:])
+}
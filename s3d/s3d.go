@@ -0,0 +1,87 @@
+// Package s3d exposes an S3-compatible gateway in front of the same
+// dataprovider.User virtual filesystems served by webdavd, sftpd and ftpd.
+package s3d
+
+import (
+	"fmt"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const logSender = "s3d"
+
+var (
+	// Config is the configuration for the s3d service, set by Initialize
+	Config Configuration
+
+	serviceStatus ServiceStatus
+)
+
+// Binding defines the configuration for a network listening endpoint
+type Binding struct {
+	// Address to bind to, leave blank to listen to all interfaces
+	Address string `json:"address" mapstructure:"address"`
+	// Port to bind to
+	Port int `json:"port" mapstructure:"port"`
+}
+
+// GetAddress returns the binding address in the host:port form used by
+// net/http
+func (b *Binding) GetAddress() string {
+	return fmt.Sprintf("%s:%d", b.Address, b.Port)
+}
+
+// Configuration holds the s3d service configuration
+type Configuration struct {
+	// Bindings is the list of address/port the gateway listens on
+	Bindings []Binding `json:"bindings" mapstructure:"bindings"`
+	// Region advertised in responses and used to validate SigV4 signatures
+	Region string `json:"region" mapstructure:"region"`
+}
+
+// ServiceStatus exposes the running bindings, mirroring the status struct
+// used by the other services for the admin API
+type ServiceStatus struct {
+	IsActive bool      `json:"is_active"`
+	Bindings []Binding `json:"bindings"`
+}
+
+// GetStatus returns the current status of the s3d service
+func GetStatus() ServiceStatus {
+	return serviceStatus
+}
+
+// Initialize configures and starts the s3d service
+func Initialize(configuration Configuration) error {
+	Config = configuration
+	serviceStatus = ServiceStatus{}
+
+	if len(Config.Bindings) == 0 {
+		logger.Debug(logSender, "", "no bindings configured, s3d service disabled")
+		return nil
+	}
+	if Config.Region == "" {
+		Config.Region = "us-east-1"
+	}
+
+	serviceStatus.IsActive = true
+	for idx := range Config.Bindings {
+		binding := Config.Bindings[idx]
+		if binding.Port == 0 {
+			continue
+		}
+		server := &s3Server{binding: binding}
+		serviceStatus.Bindings = append(serviceStatus.Bindings, binding)
+		go func() {
+			if err := server.listenAndServe(); err != nil {
+				logger.Warn(logSender, "", "s3d server on binding %#v stopped: %v", binding.GetAddress(), err)
+			}
+		}()
+	}
+	return nil
+}
+
+func isValidBucketKey(s string) bool {
+	return s != "" && !utils.IsStringInSlice("..", splitPath(s))
+}
@@ -0,0 +1,120 @@
+package s3d
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/metrics"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+type s3Server struct {
+	binding Binding
+}
+
+func (s *s3Server) listenAndServe() error {
+	httpServer := &http.Server{
+		Addr:              s.binding.GetAddress(),
+		Handler:           s,
+		ReadHeaderTimeout: 30 * time.Second,
+		IdleTimeout:       120 * time.Second,
+	}
+	logger.Info(logSender, "", "starting S3 gateway, binding: %v", s.binding.GetAddress())
+	return httpServer.ListenAndServe()
+}
+
+// ServeHTTP implements the http.Handler interface, routing S3 object and
+// bucket requests against the same dataprovider.User virtual filesystems
+// served by webdavd, sftpd and ftpd
+func (s *s3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !common.Connections.IsNewConnectionAllowed() {
+		writeS3Error(w, r, http.StatusServiceUnavailable, "ServiceUnavailable", "connection refused, configured limit reached")
+		return
+	}
+	ipAddr := utils.GetIPFromRemoteAddress(r.RemoteAddr)
+	if common.IsBanned(ipAddr) {
+		writeS3Error(w, r, http.StatusForbidden, "AccessDenied", "access denied")
+		return
+	}
+
+	user, err := s.authenticate(r)
+	if err != nil {
+		metrics.AddLoginAttempt(dataprovider.LoginMethodPassword)
+		metrics.AddLoginResult(dataprovider.LoginMethodPassword, err)
+		writeS3Error(w, r, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+		return
+	}
+
+	bucket, key := parseBucketAndKey(r.URL.Path)
+	if bucket == "" {
+		writeS3Error(w, r, http.StatusBadRequest, "InvalidBucketName", "a bucket name is required")
+		return
+	}
+	if !resolveBucket(user, bucket) {
+		writeS3Error(w, r, http.StatusNotFound, "NoSuchBucket", fmt.Sprintf("the bucket %#v does not exist", bucket))
+		return
+	}
+
+	connectionID := common.ProtocolS3 + "_" + xid.New().String()
+	fs, err := user.GetFilesystem(connectionID)
+	if err != nil {
+		writeS3Error(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	connection := NewConnection(connectionID, user, fs)
+	common.Connections.Add(connection)
+	defer common.Connections.Remove(connection.GetID())
+
+	q := r.URL.Query()
+	_, hasUploads := q["uploads"]
+	_, hasUploadID := q["uploadId"]
+
+	switch {
+	case r.Method == http.MethodPost && hasUploads:
+		s.createMultipartUpload(w, r, connection, bucket, key)
+	case r.Method == http.MethodPut && hasUploadID:
+		s.uploadPart(w, r, connection, bucket, q.Get("uploadId"), q.Get("partNumber"))
+	case r.Method == http.MethodPost && hasUploadID:
+		s.completeMultipartUpload(w, r, connection, bucket, q.Get("uploadId"))
+	case r.Method == http.MethodDelete && hasUploadID:
+		s.abortMultipartUpload(w, r, connection, bucket, q.Get("uploadId"))
+	case key == "" && r.Method == http.MethodGet:
+		s.listObjectsV2(w, r, connection, bucket)
+	case r.Method == http.MethodPut:
+		s.putObject(w, r, connection, key)
+	case r.Method == http.MethodGet:
+		s.getObject(w, r, connection, key, false)
+	case r.Method == http.MethodHead:
+		s.getObject(w, r, connection, key, true)
+	case r.Method == http.MethodDelete:
+		s.deleteObject(w, r, connection, key)
+	default:
+		writeS3Error(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "unsupported S3 operation")
+	}
+}
+
+// parseBucketAndKey splits an S3 path-style request "/bucket/key/..." into
+// the bucket name and the object key
+func parseBucketAndKey(urlPath string) (bucket, key string) {
+	trimmed := strings.TrimPrefix(urlPath, "/")
+	idx := strings.Index(trimmed, "/")
+	if idx == -1 {
+		return trimmed, ""
+	}
+	return trimmed[:idx], trimmed[idx+1:]
+}
+
+// resolveBucket reports whether bucket is served for user. This tree has
+// no virtual folder model to map arbitrary bucket names onto, so the only
+// bucket honored is the user's own username, exposing their filesystem
+// root as a single bucket named after themselves.
+func resolveBucket(user dataprovider.User, bucket string) bool {
+	return bucket == user.Username
+}
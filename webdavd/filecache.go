@@ -0,0 +1,314 @@
+package webdavd
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/metrics"
+)
+
+var (
+	sharedFileCache     *diskFileCache
+	sharedFileCacheOnce sync.Once
+)
+
+// getFileCache lazily builds the process-wide on-disk response cache used
+// to speed up repeated GETs of the same object, most noticeable when
+// PROPFIND-then-GET clients like macOS Finder hit a cloud filesystem
+// backend where every round trip is expensive. The cache is a single
+// on-disk store shared by every binding, so only the baseDir of whichever
+// binding initializes it first applies; maxSize, which changes nothing on
+// disk, is kept up to date on every call so a later binding's config isn't
+// silently dropped.
+func getFileCache(baseDir string, maxSize int64) *diskFileCache {
+	sharedFileCacheOnce.Do(func() {
+		sharedFileCache = newDiskFileCache(baseDir, maxSize)
+	})
+	sharedFileCache.mu.Lock()
+	if sharedFileCache.baseDir != baseDir {
+		logger.Warn(logSender, "", "the file cache path is process-wide, ignoring %#v for a binding already using %#v",
+			baseDir, sharedFileCache.baseDir)
+	}
+	sharedFileCache.maxSize = maxSize
+	sharedFileCache.mu.Unlock()
+	return sharedFileCache
+}
+
+type cacheEntry struct {
+	key     string
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// diskFileCache is a size-bounded LRU cache of downloaded file contents,
+// keyed by (user, path, mtime, size) so a change to the source file, either
+// its content or its modification time, naturally misses the cache instead
+// of serving stale bytes
+type diskFileCache struct {
+	baseDir string
+	maxSize int64
+
+	mu          sync.Mutex
+	currentSize int64
+	lru         *list.List
+	entries     map[string]*list.Element
+	// byPath tracks the current cache key for "username/path", regardless
+	// of the mtime/size it was stored under, so a write/rename/delete can
+	// invalidate the entry without knowing the old file's mtime and size
+	byPath map[string]string
+}
+
+func newDiskFileCache(baseDir string, maxSize int64) *diskFileCache {
+	return &diskFileCache{
+		baseDir: baseDir,
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		byPath:  make(map[string]string),
+	}
+}
+
+func fileCacheKey(username, virtualPath string, size int64, modTime time.Time) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d|%d", username, virtualPath, size, modTime.UnixNano())))
+	return hex.EncodeToString(sum[:])
+}
+
+func pathCacheKey(username, virtualPath string) string {
+	return username + "|" + virtualPath
+}
+
+// Get returns an open, seekable handle on the cached content for key, the
+// caller is responsible for closing it
+func (c *diskFileCache) Get(key string) (*os.File, bool) {
+	if c == nil || c.maxSize <= 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	elem, ok := c.entries[key]
+	if ok {
+		c.lru.MoveToFront(elem)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	f, err := os.Open(entry.path)
+	if err != nil {
+		c.removeEntry(key)
+		return nil, false
+	}
+	return f, true
+}
+
+// Put stores the content read from src under key, evicting the least
+// recently used entries if the cache grows past maxSize. Any entry
+// previously cached for username/virtualPath under a different key, e.g. a
+// stale mtime, is dropped first.
+func (c *diskFileCache) Put(username, virtualPath, key string, src io.Reader) error {
+	if c == nil || c.maxSize <= 0 {
+		return nil
+	}
+	if err := os.MkdirAll(c.baseDir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(c.baseDir, "filecache-*")
+	if err != nil {
+		return err
+	}
+	size, err := io.Copy(tmp, src)
+	if err != nil {
+		tmp.Close()           //nolint:errcheck
+		os.Remove(tmp.Name()) //nolint:errcheck
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name()) //nolint:errcheck
+		return err
+	}
+	cachePath := filepath.Join(c.baseDir, key)
+	if err := os.Rename(tmp.Name(), cachePath); err != nil {
+		os.Remove(tmp.Name()) //nolint:errcheck
+		return err
+	}
+
+	c.mu.Lock()
+	pathKey := pathCacheKey(username, virtualPath)
+	if oldKey, ok := c.byPath[pathKey]; ok && oldKey != key {
+		c.removeEntryLocked(oldKey)
+	}
+	if elem, ok := c.entries[key]; ok {
+		// same key: the file at cachePath was just replaced, only drop the
+		// stale bookkeeping, not the file
+		c.lru.Remove(elem)
+		c.currentSize -= elem.Value.(*cacheEntry).size
+	}
+	entry := &cacheEntry{key: key, path: cachePath, size: size, modTime: time.Now()}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.byPath[pathKey] = key
+	c.currentSize += size
+	c.evictLocked()
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *diskFileCache) evictLocked() {
+	for c.currentSize > c.maxSize {
+		back := c.lru.Back()
+		if back == nil {
+			break
+		}
+		entry := back.Value.(*cacheEntry)
+		c.lru.Remove(back)
+		delete(c.entries, entry.key)
+		if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+			logger.Warn(logSender, "", "unable to remove evicted cache entry %#v: %v", entry.path, err)
+		}
+		c.currentSize -= entry.size
+	}
+}
+
+func (c *diskFileCache) removeEntry(key string) {
+	c.mu.Lock()
+	c.removeEntryLocked(key)
+	c.mu.Unlock()
+}
+
+func (c *diskFileCache) removeEntryLocked(key string) {
+	elem, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	entry := elem.Value.(*cacheEntry)
+	c.lru.Remove(elem)
+	delete(c.entries, key)
+	c.currentSize -= entry.size
+	if err := os.Remove(entry.path); err != nil && !os.IsNotExist(err) {
+		logger.Warn(logSender, "", "unable to remove cache entry %#v: %v", entry.path, err)
+	}
+}
+
+// InvalidatePath drops whatever is cached for username/virtualPath,
+// regardless of the mtime/size it was stored under, it is called on any
+// write, rename or delete that goes through the connection
+func (c *diskFileCache) InvalidatePath(username, virtualPath string) {
+	if c == nil || c.maxSize <= 0 {
+		return
+	}
+	pathKey := pathCacheKey(username, virtualPath)
+	c.mu.Lock()
+	key, ok := c.byPath[pathKey]
+	if ok {
+		delete(c.byPath, pathKey)
+	}
+	c.mu.Unlock()
+	if ok {
+		c.removeEntry(key)
+	}
+}
+
+// serveCachedFile tries to serve virtualPath for connection from the shared
+// file cache, downloading and caching it first on a miss. It returns false
+// if the resource was not eligible for caching (e.g. a directory) so the
+// caller can fall back to the regular webdav.Handler. http.ServeContent
+// takes care of honoring a Range header against the cached, on-disk file.
+// A HEAD miss is answered straight from the Stat info already in hand,
+// without downloading and caching the whole object just to report its size.
+func (s *webDavServer) serveCachedFile(w http.ResponseWriter, r *http.Request, connection *Connection, username, virtualPath string) bool {
+	if !s.config.Cache.Files.Enabled {
+		return false
+	}
+	ctx := r.Context()
+	info, err := connection.Stat(ctx, virtualPath)
+	if err != nil || info.IsDir() {
+		return false
+	}
+
+	cache := getFileCache(s.config.Cache.Files.Path, s.config.Cache.Files.MaxSize)
+	key := fileCacheKey(username, virtualPath, info.Size(), info.ModTime())
+	if f, ok := cache.Get(key); ok {
+		metrics.AddWebDAVCacheHit()
+		defer f.Close()
+		http.ServeContent(w, r, virtualPath, info.ModTime(), f)
+		return true
+	}
+	metrics.AddWebDAVCacheMiss()
+
+	if r.Method == http.MethodHead {
+		if ctype := mime.TypeByExtension(filepath.Ext(virtualPath)); ctype != "" {
+			w.Header().Set("Content-Type", ctype)
+		}
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+		w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+		return true
+	}
+
+	file, err := connection.OpenFile(ctx, virtualPath, os.O_RDONLY, 0)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	if err := cache.Put(username, virtualPath, key, file); err != nil {
+		logger.Debug(logSender, "", "unable to populate file cache for %#v: %v", virtualPath, err)
+		return false
+	}
+
+	f, ok := cache.Get(key)
+	if !ok {
+		return false
+	}
+	defer f.Close()
+	http.ServeContent(w, r, virtualPath, info.ModTime(), f)
+	return true
+}
+
+// cachingFileSystem wraps a *Connection, invalidating the shared file cache
+// on any write, rename or removal that succeeds through it, so a GET never
+// serves bytes that a previous request through this same connection has
+// since overwritten or removed. Reads, including Stat and directory
+// listing, pass straight through to the wrapped Connection.
+type cachingFileSystem struct {
+	*Connection
+	cache *diskFileCache
+}
+
+func (fs *cachingFileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	file, err := fs.Connection.OpenFile(ctx, name, flag, perm)
+	if err == nil && flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		fs.cache.InvalidatePath(fs.GetUsername(), name)
+	}
+	return file, err
+}
+
+func (fs *cachingFileSystem) RemoveAll(ctx context.Context, name string) error {
+	err := fs.Connection.RemoveAll(ctx, name)
+	if err == nil {
+		fs.cache.InvalidatePath(fs.GetUsername(), name)
+	}
+	return err
+}
+
+func (fs *cachingFileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	err := fs.Connection.Rename(ctx, oldName, newName)
+	if err == nil {
+		fs.cache.InvalidatePath(fs.GetUsername(), oldName)
+		fs.cache.InvalidatePath(fs.GetUsername(), newName)
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package webdavd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiskFileCachePutAndGet(t *testing.T) {
+	cache := newDiskFileCache(t.TempDir(), 1<<20)
+	key := fileCacheKey("alice", "/file.txt", 5, time.Unix(1000, 0))
+
+	if err := cache.Put("alice", "/file.txt", key, strings.NewReader("hello")); err != nil {
+		t.Fatalf("unexpected error populating cache: %v", err)
+	}
+
+	f, ok := cache.Get(key)
+	if !ok {
+		t.Fatal("expected a cache hit right after Put")
+	}
+	f.Close()
+}
+
+func TestDiskFileCacheMissOnMtimeChange(t *testing.T) {
+	cache := newDiskFileCache(t.TempDir(), 1<<20)
+	key := fileCacheKey("alice", "/file.txt", 5, time.Unix(1000, 0))
+	if err := cache.Put("alice", "/file.txt", key, strings.NewReader("hello")); err != nil {
+		t.Fatalf("unexpected error populating cache: %v", err)
+	}
+
+	changedKey := fileCacheKey("alice", "/file.txt", 5, time.Unix(2000, 0))
+	if _, ok := cache.Get(changedKey); ok {
+		t.Fatal("expected a changed mtime to miss the cache")
+	}
+}
+
+func TestDiskFileCacheInvalidatePath(t *testing.T) {
+	cache := newDiskFileCache(t.TempDir(), 1<<20)
+	key := fileCacheKey("alice", "/file.txt", 5, time.Unix(1000, 0))
+	if err := cache.Put("alice", "/file.txt", key, strings.NewReader("hello")); err != nil {
+		t.Fatalf("unexpected error populating cache: %v", err)
+	}
+
+	cache.InvalidatePath("alice", "/file.txt")
+
+	if _, ok := cache.Get(key); ok {
+		t.Fatal("expected the entry to be gone after InvalidatePath")
+	}
+}
+
+func TestDiskFileCacheEvictsOverCapacity(t *testing.T) {
+	cache := newDiskFileCache(t.TempDir(), 5)
+
+	key1 := fileCacheKey("alice", "/a.txt", 5, time.Unix(1000, 0))
+	if err := cache.Put("alice", "/a.txt", key1, strings.NewReader("aaaaa")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	key2 := fileCacheKey("alice", "/b.txt", 5, time.Unix(1000, 0))
+	if err := cache.Put("alice", "/b.txt", key2, strings.NewReader("bbbbb")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, ok := cache.Get(key1); ok {
+		t.Fatal("expected the least recently used entry to be evicted once over maxSize")
+	}
+	f, ok := cache.Get(key2)
+	if !ok {
+		t.Fatal("expected the most recently put entry to remain cached")
+	}
+	f.Close()
+}
+
+func TestDiskFileCacheDisabledWhenMaxSizeZero(t *testing.T) {
+	cache := newDiskFileCache(t.TempDir(), 0)
+	key := fileCacheKey("alice", "/file.txt", 5, time.Unix(1000, 0))
+	if err := cache.Put("alice", "/file.txt", key, strings.NewReader("hello")); err != nil {
+		t.Fatalf("Put on a disabled cache must be a no-op, got error: %v", err)
+	}
+	if _, ok := cache.Get(key); ok {
+		t.Fatal("expected a disabled (maxSize<=0) cache to never report a hit")
+	}
+}
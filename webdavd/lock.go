@@ -0,0 +1,309 @@
+package webdavd
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+	"golang.org/x/net/webdav"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+)
+
+const lockSweepInterval = 1 * time.Minute
+
+var (
+	sharedLockSystem     *persistentLockSystem
+	sharedLockSystemOnce sync.Once
+)
+
+// getSharedLockSystem returns the process-wide lock state used by every
+// WebDAV connection. It replaces the previous per-user webdav.NewMemLS():
+// locks are now held in a single lockMtx-guarded map keyed by lock token,
+// each entry recording the user and path it covers, and mirrored to the
+// dataprovider, so a LOCK token survives a restart. The
+// sweeper also reloads the dataprovider's lock table every
+// lockSweepInterval, so a LOCK created on another node of a multi-node
+// deployment becomes visible here within that interval rather than only
+// on this node's next restart.
+func getSharedLockSystem() *persistentLockSystem {
+	sharedLockSystemOnce.Do(func() {
+		sharedLockSystem = newPersistentLockSystem()
+		sharedLockSystem.restore()
+		go sharedLockSystem.startSweeper()
+	})
+	return sharedLockSystem
+}
+
+// forUser returns a webdav.LockSystem facade bound to username, suitable
+// for the LockSystem field of a per-request webdav.Handler
+func (ls *persistentLockSystem) forUser(username string) webdav.LockSystem {
+	return &userLockSystem{username: username, shared: ls}
+}
+
+type lockEntry struct {
+	dataprovider.WebDAVLock
+}
+
+func (e *lockEntry) isExpired() bool {
+	return e.Expiration.Before(time.Now())
+}
+
+// persistentLockSystem holds the process-wide lock state: a lockMtx-guarded
+// token table, mirrored into the dataprovider for persistence and
+// periodically reloaded from it so a lock created on another node becomes
+// visible here. Confirm/Create scan this table for a live lock on the
+// requested username+path so a held lock actually blocks conflicting
+// writers instead of just tracking tokens.
+type persistentLockSystem struct {
+	lockMtx sync.Mutex
+	tokens  map[string]*lockEntry
+}
+
+func newPersistentLockSystem() *persistentLockSystem {
+	return &persistentLockSystem{
+		tokens: make(map[string]*lockEntry),
+	}
+}
+
+func (ls *persistentLockSystem) restore() {
+	ls.reload()
+	logger.Debug(logSender, "", "restored %v persisted webdav locks", len(ls.tokens))
+}
+
+// reload re-syncs the in-memory token table against the dataprovider, so a
+// lock created on, or removed from, another node of a multi-node
+// deployment is reflected here instead of only at this node's own
+// confirm/create/unlock calls
+func (ls *persistentLockSystem) reload() {
+	locks, err := dataprovider.GetWebDAVLocks()
+	if err != nil {
+		logger.Warn(logSender, "", "unable to reload persisted webdav locks: %v", err)
+		return
+	}
+	remote := make(map[string]dataprovider.WebDAVLock, len(locks))
+	for _, lock := range locks {
+		if !lock.IsExpired() {
+			remote[lock.Token] = lock
+		}
+	}
+
+	ls.lockMtx.Lock()
+	defer ls.lockMtx.Unlock()
+	for token := range ls.tokens {
+		if _, ok := remote[token]; !ok {
+			delete(ls.tokens, token)
+		}
+	}
+	for token, lock := range remote {
+		if _, ok := ls.tokens[token]; !ok {
+			ls.tokens[token] = &lockEntry{WebDAVLock: lock}
+		}
+	}
+}
+
+// confirm fails with webdav.ErrConfirmationFailed if name0/name1 is held by
+// a live lock for username whose token was not supplied among conditions,
+// so a LOCK held by one writer actually blocks every other writer instead
+// of only rejecting an explicitly supplied, unknown token.
+func (ls *persistentLockSystem) confirm(now time.Time, username, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	ls.lockMtx.Lock()
+	defer ls.lockMtx.Unlock()
+
+	providedTokens := make(map[string]bool, len(conditions))
+	for _, cond := range conditions {
+		if cond.Token == "" {
+			continue
+		}
+		entry, ok := ls.tokens[cond.Token]
+		if !ok || entry.isExpired() {
+			return nil, webdav.ErrConfirmationFailed
+		}
+		providedTokens[cond.Token] = true
+	}
+
+	for _, name := range [...]string{name0, name1} {
+		if name == "" {
+			continue
+		}
+		if ls.isLockedByOtherLocked(username, name, providedTokens) {
+			return nil, webdav.ErrConfirmationFailed
+		}
+	}
+	return func() {}, nil
+}
+
+// isLockedByOtherLocked reports whether name is held by a live lock for
+// username whose token isn't in providedTokens. Must be called with
+// lockMtx held.
+func (ls *persistentLockSystem) isLockedByOtherLocked(username, name string, providedTokens map[string]bool) bool {
+	for token, entry := range ls.tokens {
+		if entry.isExpired() || entry.Username != username || entry.Path != name {
+			continue
+		}
+		if !providedTokens[token] {
+			return true
+		}
+	}
+	return false
+}
+
+// create rejects a LOCK on a root already held by a live lock for username,
+// so two concurrent clients can't both "lock" the same resource.
+func (ls *persistentLockSystem) create(username string, now time.Time, details webdav.LockDetails) (string, error) {
+	ls.lockMtx.Lock()
+	if ls.isLockedByOtherLocked(username, details.Root, nil) {
+		ls.lockMtx.Unlock()
+		return "", webdav.ErrLocked
+	}
+	ls.lockMtx.Unlock()
+
+	token := fmt.Sprintf("opaquelocktoken:%v", xid.New().String())
+	lock := dataprovider.WebDAVLock{
+		Token:      token,
+		Username:   username,
+		Path:       details.Root,
+		Owner:      details.OwnerXML,
+		ZeroDepth:  details.ZeroDepth,
+		Expiration: now.Add(details.Duration),
+	}
+	if err := dataprovider.AddWebDAVLock(lock); err != nil {
+		return "", err
+	}
+
+	ls.lockMtx.Lock()
+	// re-check under lockMtx: a concurrent Create for the same root may
+	// have won the race while AddWebDAVLock ran unlocked
+	if ls.isLockedByOtherLocked(username, details.Root, nil) {
+		ls.lockMtx.Unlock()
+		dataprovider.RemoveWebDAVLock(token) //nolint:errcheck
+		return "", webdav.ErrLocked
+	}
+	ls.tokens[token] = &lockEntry{WebDAVLock: lock}
+	ls.lockMtx.Unlock()
+
+	return token, nil
+}
+
+func (ls *persistentLockSystem) refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	ls.lockMtx.Lock()
+	defer ls.lockMtx.Unlock()
+
+	entry, ok := ls.tokens[token]
+	if !ok || entry.isExpired() {
+		return webdav.LockDetails{}, webdav.ErrNoSuchLock
+	}
+	entry.Expiration = now.Add(duration)
+	if err := dataprovider.UpdateWebDAVLock(token, entry.Expiration); err != nil {
+		return webdav.LockDetails{}, err
+	}
+	return webdav.LockDetails{
+		Root:      entry.Path,
+		Duration:  duration,
+		OwnerXML:  entry.Owner,
+		ZeroDepth: entry.ZeroDepth,
+	}, nil
+}
+
+func (ls *persistentLockSystem) unlock(token string) (dataprovider.WebDAVLock, error) {
+	ls.lockMtx.Lock()
+	entry, ok := ls.tokens[token]
+	if !ok {
+		ls.lockMtx.Unlock()
+		return dataprovider.WebDAVLock{}, webdav.ErrNoSuchLock
+	}
+	delete(ls.tokens, token)
+	ls.lockMtx.Unlock()
+
+	if err := dataprovider.RemoveWebDAVLock(token); err != nil {
+		return dataprovider.WebDAVLock{}, err
+	}
+	return entry.WebDAVLock, nil
+}
+
+// startSweeper periodically expires locks past their TTL, it is started
+// once on first use of the shared lock system
+func (ls *persistentLockSystem) startSweeper() {
+	ticker := time.NewTicker(lockSweepInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ls.tidy()
+	}
+}
+
+// tidy expires stale locks
+func (ls *persistentLockSystem) tidy() {
+	ls.lockMtx.Lock()
+	var expired []string
+	for token, entry := range ls.tokens {
+		if entry.isExpired() {
+			expired = append(expired, token)
+			delete(ls.tokens, token)
+		}
+	}
+	ls.lockMtx.Unlock()
+
+	for _, token := range expired {
+		if err := dataprovider.RemoveWebDAVLock(token); err != nil {
+			logger.Warn(logSender, "", "unable to remove expired webdav lock %#v: %v", token, err)
+		}
+	}
+	if len(expired) > 0 {
+		logger.Debug(logSender, "", "lockTidied: expired %v stale webdav locks", len(expired))
+	}
+
+	ls.reload()
+}
+
+// ActiveLocks returns a snapshot of the currently held locks, exposed
+// through the admin API so operators can diagnose stuck class-2 locks left
+// behind by Office or macOS Finder clients
+func ActiveLocks() []dataprovider.WebDAVLock {
+	if sharedLockSystem == nil {
+		return nil
+	}
+	sharedLockSystem.lockMtx.Lock()
+	defer sharedLockSystem.lockMtx.Unlock()
+
+	locks := make([]dataprovider.WebDAVLock, 0, len(sharedLockSystem.tokens))
+	for _, entry := range sharedLockSystem.tokens {
+		locks = append(locks, entry.WebDAVLock)
+	}
+	return locks
+}
+
+// userLockSystem is the per-connection webdav.LockSystem handed to
+// webdav.Handler for username, it delegates state to the shared, persisted
+// lock table which enforces a path's exclusivity across every connection
+type userLockSystem struct {
+	username string
+	shared   *persistentLockSystem
+}
+
+func (ls *userLockSystem) Confirm(now time.Time, name0, name1 string, conditions ...webdav.Condition) (func(), error) {
+	return ls.shared.confirm(now, ls.username, name0, name1, conditions...)
+}
+
+func (ls *userLockSystem) Create(now time.Time, details webdav.LockDetails) (string, error) {
+	token, err := ls.shared.create(ls.username, now, details)
+	if err != nil {
+		return "", err
+	}
+	logger.Info(logSender, "", "user %#v locked %#v", ls.username, details.Root)
+	return token, nil
+}
+
+func (ls *userLockSystem) Refresh(now time.Time, token string, duration time.Duration) (webdav.LockDetails, error) {
+	return ls.shared.refresh(now, token, duration)
+}
+
+func (ls *userLockSystem) Unlock(now time.Time, token string) error {
+	lock, err := ls.shared.unlock(token)
+	if err != nil {
+		return err
+	}
+	logger.Info(logSender, "", "user %#v unlocked %#v", lock.Username, lock.Path)
+	return nil
+}
@@ -0,0 +1,88 @@
+package webdavd
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+)
+
+// putLock seeds ls.tokens directly, bypassing create's dataprovider
+// persistence so these tests can exercise the in-memory exclusivity check
+// on its own
+func putLock(ls *persistentLockSystem, token, username, path string) {
+	ls.lockMtx.Lock()
+	defer ls.lockMtx.Unlock()
+	ls.tokens[token] = &lockEntry{WebDAVLock: dataprovider.WebDAVLock{
+		Token:      token,
+		Username:   username,
+		Path:       path,
+		Expiration: time.Now().Add(time.Hour),
+	}}
+}
+
+func TestConfirmBlocksOtherWriter(t *testing.T) {
+	ls := newPersistentLockSystem()
+	putLock(ls, "opaquelocktoken:holder", "alice", "/shared/file.txt")
+
+	if _, err := ls.confirm(time.Now(), "bob", "/shared/file.txt", ""); err != webdav.ErrConfirmationFailed {
+		t.Fatalf("expected a second writer to be blocked by the held lock, got: %v", err)
+	}
+}
+
+func TestConfirmAllowsHolderWithToken(t *testing.T) {
+	ls := newPersistentLockSystem()
+	putLock(ls, "opaquelocktoken:holder", "alice", "/shared/file.txt")
+
+	release, err := ls.confirm(time.Now(), "alice", "/shared/file.txt", "", webdav.Condition{Token: "opaquelocktoken:holder"})
+	if err != nil {
+		t.Fatalf("expected the lock holder presenting its token to be allowed, got: %v", err)
+	}
+	release()
+}
+
+func TestConfirmAllowsUnrelatedPath(t *testing.T) {
+	ls := newPersistentLockSystem()
+	putLock(ls, "opaquelocktoken:holder", "alice", "/shared/file.txt")
+
+	release, err := ls.confirm(time.Now(), "bob", "/shared/other.txt", "")
+	if err != nil {
+		t.Fatalf("expected an unlocked path to be allowed, got: %v", err)
+	}
+	release()
+}
+
+func TestConfirmIgnoresExpiredLock(t *testing.T) {
+	ls := newPersistentLockSystem()
+	ls.lockMtx.Lock()
+	ls.tokens["opaquelocktoken:stale"] = &lockEntry{WebDAVLock: dataprovider.WebDAVLock{
+		Token:      "opaquelocktoken:stale",
+		Username:   "alice",
+		Path:       "/shared/file.txt",
+		Expiration: time.Now().Add(-time.Minute),
+	}}
+	ls.lockMtx.Unlock()
+
+	release, err := ls.confirm(time.Now(), "bob", "/shared/file.txt", "")
+	if err != nil {
+		t.Fatalf("expected an expired lock not to block a new writer, got: %v", err)
+	}
+	release()
+}
+
+func TestIsLockedByOtherLocked(t *testing.T) {
+	ls := newPersistentLockSystem()
+	putLock(ls, "opaquelocktoken:holder", "alice", "/shared/file.txt")
+
+	ls.lockMtx.Lock()
+	defer ls.lockMtx.Unlock()
+
+	if !ls.isLockedByOtherLocked("bob", "/shared/file.txt", nil) {
+		t.Error("expected the path to be reported as locked for a different username")
+	}
+	if ls.isLockedByOtherLocked("alice", "/shared/file.txt", map[string]bool{"opaquelocktoken:holder": true}) {
+		t.Error("expected the holder's own token to clear the lock")
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"path"
 	"path/filepath"
 	"runtime/debug"
@@ -148,14 +149,38 @@ func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, common.ErrConnectionDenied.Error(), http.StatusForbidden)
 		return
 	}
-	user, _, lockSystem, err := s.authenticate(r, ipAddr)
+
+	if token, subPath, ok := parseShareRequest(r.URL.Path); ok {
+		s.serveShare(w, r, ipAddr, token, subPath)
+		return
+	}
+
+	vhostPrincipal, vhostIsShare, vhostOK := resolveVirtualHost(r.Host, s.binding.HostPattern)
+	if vhostOK && vhostIsShare {
+		// a share resolved from the Host is served the same way as one
+		// resolved from the /s/<token> path
+		s.serveShare(w, r, ipAddr, vhostPrincipal, r.URL.Path)
+		return
+	}
+	vhostUser := ""
+	if vhostOK {
+		vhostUser = vhostPrincipal
+	}
+
+	user, loginMethod, lockSystem, claims, err := s.authenticate(r, ipAddr, vhostUser)
 	if err != nil {
 		w.Header().Set("WWW-Authenticate", "Basic realm=\"SFTPGo WebDAV\"")
 		http.Error(w, err401.Error(), http.StatusUnauthorized)
 		return
 	}
 
-	connectionID, err := s.validateUser(&user, r)
+	if err := enforceTokenScope(claims, r); err != nil {
+		logger.Debug(logSender, "", "request denied for user %#v by token scope: %v", user.Username, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	connectionID, err := s.validateUser(&user, r, loginMethod)
 	if err != nil {
 		updateLoginMetrics(&user, ipAddr, err)
 		http.Error(w, err.Error(), http.StatusForbidden)
@@ -190,20 +215,46 @@ func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		if s.serveCachedFile(w, r.WithContext(ctx), connection, user.Username, path.Clean(r.URL.Path)) {
+			return
+		}
+	}
+
+	var fileSystem webdav.FileSystem = connection
+	if s.config.Cache.Files.Enabled {
+		fileSystem = &cachingFileSystem{
+			Connection: connection,
+			cache:      getFileCache(s.config.Cache.Files.Path, s.config.Cache.Files.MaxSize),
+		}
+	}
+
 	handler := webdav.Handler{
-		FileSystem: connection,
+		FileSystem: fileSystem,
 		LockSystem: lockSystem,
 		Logger:     writeLog,
 	}
 	handler.ServeHTTP(w, r.WithContext(ctx))
 }
 
-func (s *webDavServer) authenticate(r *http.Request, ip string) (dataprovider.User, bool, webdav.LockSystem, error) {
+// authenticate resolves the user performing the request. vhostUser, when
+// non-empty, is the principal resolved from the request Host by the virtual
+// host routing layer and takes precedence over the Basic auth username so
+// that a misconfigured client cannot authenticate as a different tenant
+// than the one the subdomain names.
+func (s *webDavServer) authenticate(r *http.Request, ip, vhostUser string) (dataprovider.User, string, webdav.LockSystem, dataprovider.TokenClaims, error) {
+	if token, ok := getBearerToken(r); ok {
+		return s.authenticateToken(token, ip)
+	}
+
 	var user dataprovider.User
+	var claims dataprovider.TokenClaims
 	var err error
 	username, password, ok := r.BasicAuth()
-	if !ok {
-		return user, false, nil, err401
+	if vhostUser != "" {
+		username = vhostUser
+	} else if !ok {
+		return user, dataprovider.LoginMethodPassword, nil, claims, err401
 	}
 	result, ok := dataprovider.GetCachedWebDAVUser(username)
 	if ok {
@@ -212,19 +263,19 @@ func (s *webDavServer) authenticate(r *http.Request, ip string) (dataprovider.Us
 			dataprovider.RemoveCachedWebDAVUser(username)
 		} else {
 			if password != "" && cachedUser.Password == password {
-				return cachedUser.User, true, cachedUser.LockSystem, nil
+				return cachedUser.User, dataprovider.LoginMethodPassword, cachedUser.LockSystem, claims, nil
 			}
 			updateLoginMetrics(&cachedUser.User, ip, dataprovider.ErrInvalidCredentials)
-			return user, false, nil, dataprovider.ErrInvalidCredentials
+			return user, dataprovider.LoginMethodPassword, nil, claims, dataprovider.ErrInvalidCredentials
 		}
 	}
 	user, err = dataprovider.CheckUserAndPass(username, password, ip, common.ProtocolWebDAV)
 	if err != nil {
 		user.Username = username
 		updateLoginMetrics(&user, ip, err)
-		return user, false, nil, err
+		return user, dataprovider.LoginMethodPassword, nil, claims, err
 	}
-	lockSystem := webdav.NewMemLS()
+	lockSystem := getSharedLockSystem().forUser(user.Username)
 	if password != "" {
 		cachedUser := &dataprovider.CachedUser{
 			User:       user,
@@ -244,10 +295,97 @@ func (s *webDavServer) authenticate(r *http.Request, ip string) (dataprovider.Us
 			}
 		}
 	}
-	return user, false, lockSystem, nil
+	return user, dataprovider.LoginMethodPassword, lockSystem, claims, nil
+}
+
+// webdavWriteMethods are the request methods that mutate the tree, used to
+// enforce a bearer token's ReadOnly claim
+var webdavWriteMethods = map[string]bool{
+	http.MethodPut:    true,
+	http.MethodDelete: true,
+	"MKCOL":           true,
+	"MOVE":            true,
+	"COPY":            true,
+	"PROPPATCH":       true,
+}
+
+// enforceTokenScope applies the ReadOnly/PathScope restrictions carried by a
+// bearer token's claims. It is a no-op for password authentication, whose
+// claims are always the zero value.
+func enforceTokenScope(claims dataprovider.TokenClaims, r *http.Request) error {
+	if claims.PathScope != "" {
+		if !isPathWithinScope(path.Clean(r.URL.Path), claims.PathScope) {
+			return fmt.Errorf("token is scoped to %#v", claims.PathScope)
+		}
+		if dest := r.Header.Get("Destination"); dest != "" {
+			if u, err := url.Parse(dest); err == nil && !isPathWithinScope(path.Clean(u.Path), claims.PathScope) {
+				return fmt.Errorf("token is scoped to %#v", claims.PathScope)
+			}
+		}
+	}
+	if claims.ReadOnly && webdavWriteMethods[r.Method] {
+		return errors.New("token grants read-only access")
+	}
+	return nil
+}
+
+// isPathWithinScope returns true if p is scope itself or one of its
+// descendants
+func isPathWithinScope(p, scope string) bool {
+	if scope == "" {
+		return true
+	}
+	scope = path.Clean(scope)
+	if scope == "/" {
+		return true
+	}
+	return p == scope || strings.HasPrefix(p, scope+"/")
+}
+
+// getBearerToken returns the token carried by an "Authorization: Bearer <token>"
+// header, if any
+func getBearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", false
+	}
+	return strings.TrimPrefix(header, "Bearer "), true
+}
+
+// authenticateToken maps a bearer token to a dataprovider.User, the cached
+// path keys on the token hash instead of the password so different tokens
+// issued to the same user are tracked independently. The token's claims are
+// returned alongside the user so ServeHTTP can enforce any ReadOnly/
+// PathScope restriction it carries.
+func (s *webDavServer) authenticateToken(token, ip string) (dataprovider.User, string, webdav.LockSystem, dataprovider.TokenClaims, error) {
+	var user dataprovider.User
+	tokenHash := dataprovider.HashToken(token)
+
+	if cached, ok := dataprovider.GetCachedWebDAVUserByToken(tokenHash); ok {
+		if cached.IsExpired() {
+			dataprovider.RemoveCachedWebDAVUserByToken(tokenHash)
+		} else {
+			return cached.User, dataprovider.LoginMethodToken, cached.LockSystem, cached.Claims, nil
+		}
+	}
+
+	user, claims, err := dataprovider.CheckUserToken(token, ip, common.ProtocolWebDAV)
+	if err != nil {
+		updateLoginMetrics(&user, ip, err)
+		return user, dataprovider.LoginMethodToken, nil, claims, err401
+	}
+
+	lockSystem := getSharedLockSystem().forUser(user.Username)
+	cachedUser := &dataprovider.CachedTokenUser{
+		User:       user,
+		Claims:     claims,
+		LockSystem: lockSystem,
+	}
+	dataprovider.CacheWebDAVUserByToken(tokenHash, cachedUser, s.config.Cache.Users.MaxSize)
+	return user, dataprovider.LoginMethodToken, lockSystem, claims, nil
 }
 
-func (s *webDavServer) validateUser(user *dataprovider.User, r *http.Request) (string, error) {
+func (s *webDavServer) validateUser(user *dataprovider.User, r *http.Request, loginMethod string) (string, error) {
 	connID := xid.New().String()
 	connectionID := fmt.Sprintf("%v_%v", common.ProtocolWebDAV, connID)
 
@@ -260,9 +398,9 @@ func (s *webDavServer) validateUser(user *dataprovider.User, r *http.Request) (s
 		logger.Debug(logSender, connectionID, "cannot login user %#v, protocol DAV is not allowed", user.Username)
 		return connID, fmt.Errorf("Protocol DAV is not allowed for user %#v", user.Username)
 	}
-	if !user.IsLoginMethodAllowed(dataprovider.LoginMethodPassword, nil) {
-		logger.Debug(logSender, connectionID, "cannot login user %#v, password login method is not allowed", user.Username)
-		return connID, fmt.Errorf("Password login method is not allowed for user %#v", user.Username)
+	if !user.IsLoginMethodAllowed(loginMethod, nil) {
+		logger.Debug(logSender, connectionID, "cannot login user %#v, %#v login method is not allowed", user.Username, loginMethod)
+		return connID, fmt.Errorf("%#v login method is not allowed for user %#v", loginMethod, user.Username)
 	}
 	if user.MaxSessions > 0 {
 		activeSessions := common.Connections.GetActiveSessions(user.Username)
@@ -0,0 +1,77 @@
+package webdavd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+)
+
+func TestEnforceTokenScopeReadOnly(t *testing.T) {
+	claims := dataprovider.TokenClaims{ReadOnly: true}
+
+	for _, method := range []string{http.MethodPut, http.MethodDelete, "MKCOL", "MOVE", "COPY", "PROPPATCH"} {
+		r := httptest.NewRequest(method, "/shared/file.txt", nil)
+		if err := enforceTokenScope(claims, r); err == nil {
+			t.Errorf("expected a read-only token to block %v", method)
+		}
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodHead, "PROPFIND"} {
+		r := httptest.NewRequest(method, "/shared/file.txt", nil)
+		if err := enforceTokenScope(claims, r); err != nil {
+			t.Errorf("expected a read-only token to allow %v, got: %v", method, err)
+		}
+	}
+}
+
+func TestEnforceTokenScopePathScope(t *testing.T) {
+	claims := dataprovider.TokenClaims{PathScope: "/shared"}
+
+	r := httptest.NewRequest(http.MethodGet, "/shared/file.txt", nil)
+	if err := enforceTokenScope(claims, r); err != nil {
+		t.Fatalf("expected a request within scope to be allowed, got: %v", err)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/other/file.txt", nil)
+	if err := enforceTokenScope(claims, r); err == nil {
+		t.Fatal("expected a request outside scope to be rejected")
+	}
+}
+
+func TestEnforceTokenScopeDestinationEscape(t *testing.T) {
+	claims := dataprovider.TokenClaims{PathScope: "/shared"}
+
+	r := httptest.NewRequest("MOVE", "/shared/file.txt", nil)
+	r.Header.Set("Destination", "/shared/renamed.txt")
+	if err := enforceTokenScope(claims, r); err != nil {
+		t.Fatalf("expected a Destination within scope to be allowed, got: %v", err)
+	}
+
+	r = httptest.NewRequest("MOVE", "/shared/file.txt", nil)
+	r.Header.Set("Destination", "/other/escaped.txt")
+	if err := enforceTokenScope(claims, r); err == nil {
+		t.Fatal("expected a Destination escaping the path scope to be rejected")
+	}
+}
+
+func TestIsPathWithinScope(t *testing.T) {
+	cases := []struct {
+		path  string
+		scope string
+		want  bool
+	}{
+		{"/shared", "/shared", true},
+		{"/shared/sub", "/shared", true},
+		{"/sharedother", "/shared", false},
+		{"/other", "/shared", false},
+		{"/anything", "/", true},
+		{"/anything", "", true},
+	}
+	for _, c := range cases {
+		if got := isPathWithinScope(c.path, c.scope); got != c.want {
+			t.Errorf("isPathWithinScope(%#v, %#v) = %v, want %v", c.path, c.scope, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package webdavd
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+	"golang.org/x/net/webdav"
+
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+)
+
+const shareURLPrefix = "/s/"
+
+// parseShareRequest splits a share URL of the form /s/<token>/<subPath> into
+// its token and the path within the share, the latter defaulting to "/"
+func parseShareRequest(urlPath string) (token, subPath string, ok bool) {
+	if !strings.HasPrefix(urlPath, shareURLPrefix) {
+		return "", "", false
+	}
+	rest := strings.TrimPrefix(urlPath, shareURLPrefix)
+	idx := strings.Index(rest, "/")
+	if idx == -1 {
+		return rest, "/", rest != ""
+	}
+	return rest[:idx], rest[idx:], rest[:idx] != ""
+}
+
+// serveShare handles GET/HEAD/PROPFIND against a public share: it
+// materializes a synthetic, read-only Connection scoped to the share's root
+// and reuses the regular webdav.Handler to serve it
+func (s *webDavServer) serveShare(w http.ResponseWriter, r *http.Request, ipAddr, token, subPath string) {
+	share, err := dataprovider.ShareExists(token)
+	if err != nil {
+		http.Error(w, "share not found", http.StatusNotFound)
+		return
+	}
+	if share.IsExpired() {
+		http.Error(w, "share expired", http.StatusGone)
+		return
+	}
+	if !share.IsIPAllowed(ipAddr) {
+		http.Error(w, common.ErrConnectionDenied.Error(), http.StatusForbidden)
+		return
+	}
+	if share.HasPassword() {
+		_, password, ok := r.BasicAuth()
+		if !ok || !share.CheckPassword(password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="SFTPGo share"`)
+			http.Error(w, err401.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != "PROPFIND" {
+		http.Error(w, "method not allowed for a share", http.StatusMethodNotAllowed)
+		return
+	}
+
+	owner, err := dataprovider.UserExists(share.Owner)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to load owner %#v for share %#v: %v", share.Owner, token, err)
+		http.Error(w, common.ErrGenericFailure.Error(), http.StatusInternalServerError)
+		return
+	}
+	sharePath := path.Clean(share.Path)
+	// scope the share through the permission/virtual-path layer rather than
+	// mutating HomeDir: HomeDir is a local filesystem concept and has no
+	// meaning for S3/GCS/other backends, where the root comes from the
+	// FsConfig prefix instead, so joining share.Path onto it would leave
+	// non-local backends exposing the owner's whole filesystem
+	owner.Permissions = map[string][]string{sharePath: {dataprovider.PermListItems, dataprovider.PermDownload}}
+
+	connectionID := fmt.Sprintf("%v_share_%v", common.ProtocolWebDAV, xid.New().String())
+	fs, err := owner.GetFilesystem(connectionID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(connectionID, common.ProtocolWebDAV, owner, fs),
+		request:        r,
+	}
+	common.Connections.Add(connection)
+	defer common.Connections.Remove(connection.GetID())
+
+	ctx := context.WithValue(r.Context(), requestIDKey, connectionID)
+	ctx = context.WithValue(ctx, requestStartKey, time.Now())
+
+	virtualPath := path.Join(sharePath, subPath)
+
+	shareRequest := r.Clone(ctx)
+	shareRequest.URL.Path = virtualPath
+
+	isDir := false
+	if info, statErr := connection.Stat(ctx, virtualPath); statErr == nil {
+		isDir = info.IsDir()
+	}
+	if shareRequest.Method == http.MethodGet && isDir && wantsHTMLListing(shareRequest) {
+		s.serveShareListing(w, connection, ctx, subPath, virtualPath)
+		return
+	}
+
+	if s.checkRequestMethod(ctx, shareRequest, connection) {
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte("")) //nolint:errcheck
+		return
+	}
+
+	// count a share download only once per completed, whole-file GET: not on
+	// a directory listing, a HEAD/PROPFIND, or a byte-range/partial request,
+	// otherwise the counter is inflated by every range chunk a media player
+	// or resumed download issues for the same file
+	countDownload := shareRequest.Method == http.MethodGet && !isDir && shareRequest.Header.Get("Range") == ""
+
+	handler := webdav.Handler{
+		FileSystem: connection,
+		LockSystem: webdav.NewMemLS(),
+		Logger:     writeLog,
+	}
+	rw := &statusCapturingWriter{ResponseWriter: w, statusCode: http.StatusOK}
+	handler.ServeHTTP(rw, shareRequest)
+
+	if countDownload && rw.statusCode == http.StatusOK {
+		if err := dataprovider.IncrementShareDownloads(token); err != nil {
+			logger.Warn(logSender, "", "unable to increment downloads for share %#v: %v", token, err)
+		}
+	}
+}
+
+// statusCapturingWriter records the status code written through it so the
+// caller can inspect the outcome of handler.ServeHTTP after the fact
+type statusCapturingWriter struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (w *statusCapturingWriter) WriteHeader(statusCode int) {
+	w.statusCode = statusCode
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// wantsHTMLListing returns true if the client looks like a browser rather
+// than a WebDAV client, used to decide whether to render an HTML directory
+// listing instead of a raw GET of the directory
+func wantsHTMLListing(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+func (s *webDavServer) serveShareListing(w http.ResponseWriter, connection *Connection, ctx context.Context, subPath, virtualPath string) {
+	f, err := connection.OpenFile(ctx, virtualPath, os.O_RDONLY, 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	entries, err := f.Readdir(-1)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "<!DOCTYPE html><html><head><title>Index of %s</title></head><body>", html.EscapeString(subPath))
+	fmt.Fprintf(w, "<h1>Index of %s</h1><ul>", html.EscapeString(subPath))
+	if subPath != "/" {
+		fmt.Fprint(w, `<li><a href="../">..</a></li>`)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		fmt.Fprintf(w, `<li><a href="%s">%s</a></li>`, url.PathEscape(name), html.EscapeString(name))
+	}
+	fmt.Fprint(w, "</ul></body></html>")
+}
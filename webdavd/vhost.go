@@ -0,0 +1,51 @@
+package webdavd
+
+import "strings"
+
+const (
+	vhostUserPlaceholder  = "{username}"
+	vhostSharePlaceholder = "{share}"
+)
+
+// resolveVirtualHost inspects host against pattern (e.g.
+// "{username}.dav.example.com" or "{share}--dav.example.com") and, if it
+// matches, returns the resolved principal (a username or a share token) and
+// whether it names a share. Both the "." and the "--" separators are
+// accepted regardless of which one pattern uses, the latter lets admins
+// serve every tenant under a single second-level wildcard certificate.
+func resolveVirtualHost(host, pattern string) (principal string, isShare bool, ok bool) {
+	if pattern == "" {
+		return "", false, false
+	}
+	host = stripHostPort(host)
+
+	placeholder := vhostUserPlaceholder
+	if strings.Contains(pattern, vhostSharePlaceholder) {
+		placeholder, isShare = vhostSharePlaceholder, true
+	} else if !strings.Contains(pattern, vhostUserPlaceholder) {
+		return "", false, false
+	}
+
+	idx := strings.Index(pattern, placeholder)
+	domain := strings.TrimLeft(pattern[idx+len(placeholder):], ".-")
+	if domain == "" {
+		return "", false, false
+	}
+
+	for _, sep := range [...]string{".", "--"} {
+		suffix := sep + domain
+		if strings.HasSuffix(host, suffix) {
+			if principal = strings.TrimSuffix(host, suffix); principal != "" {
+				return principal, isShare, true
+			}
+		}
+	}
+	return "", false, false
+}
+
+func stripHostPort(host string) string {
+	if idx := strings.LastIndex(host, ":"); idx != -1 {
+		return host[:idx]
+	}
+	return host
+}
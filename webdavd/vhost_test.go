@@ -0,0 +1,42 @@
+package webdavd
+
+import "testing"
+
+func TestResolveVirtualHostUser(t *testing.T) {
+	principal, isShare, ok := resolveVirtualHost("alice.dav.example.com", "{username}.dav.example.com")
+	if !ok || isShare || principal != "alice" {
+		t.Fatalf("got principal=%#v isShare=%v ok=%v", principal, isShare, ok)
+	}
+}
+
+func TestResolveVirtualHostUserWithPort(t *testing.T) {
+	principal, isShare, ok := resolveVirtualHost("alice.dav.example.com:8080", "{username}.dav.example.com")
+	if !ok || isShare || principal != "alice" {
+		t.Fatalf("got principal=%#v isShare=%v ok=%v", principal, isShare, ok)
+	}
+}
+
+func TestResolveVirtualHostShareDoubleDash(t *testing.T) {
+	principal, isShare, ok := resolveVirtualHost("tok123--dav.example.com", "{share}--dav.example.com")
+	if !ok || !isShare || principal != "tok123" {
+		t.Fatalf("got principal=%#v isShare=%v ok=%v", principal, isShare, ok)
+	}
+}
+
+func TestResolveVirtualHostNoMatch(t *testing.T) {
+	if _, _, ok := resolveVirtualHost("unrelated.example.com", "{username}.dav.example.com"); ok {
+		t.Fatal("expected a host not matching the pattern's domain to fail to resolve")
+	}
+}
+
+func TestResolveVirtualHostEmptyPrincipal(t *testing.T) {
+	if _, _, ok := resolveVirtualHost(".dav.example.com", "{username}.dav.example.com"); ok {
+		t.Fatal("expected an empty principal segment to fail to resolve")
+	}
+}
+
+func TestResolveVirtualHostNoPattern(t *testing.T) {
+	if _, _, ok := resolveVirtualHost("alice.dav.example.com", ""); ok {
+		t.Fatal("expected an empty pattern to never match")
+	}
+}